@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuthMiddleware 要求 /admin/* 路由携带 `Authorization: Bearer <admin_token>`
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, hasBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !hasBearer || token == "" || token != getConfig().AdminToken {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleConfigReload 重新读取 config.yaml 并替换当前配置
+func handleConfigReload(c *gin.Context) {
+	cfg, err := loadConfigFile("config.yaml")
+	if err != nil {
+		slog.Error("重新加载配置文件错误", "错误信息", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reloading config"})
+		return
+	}
+	setConfig(cfg)
+	initializeRouter()
+	slog.Info("配置已重新加载")
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
+
+type warningRequest struct {
+	WarningMsg string `json:"warning_msg" binding:"required"`
+}
+
+// handleConfigWarning 运行时更新警告文案
+func handleConfigWarning(c *gin.Context) {
+	var req warningRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	configMu.Lock()
+	config.WarningMsg = req.WarningMsg
+	configMu.Unlock()
+	slog.Info("已更新警告文案")
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+type whitelistRequest struct {
+	WhiteListModels []string `json:"white_list_models" binding:"required"`
+}
+
+// handleConfigWhitelist 运行时整体替换模型白名单
+func handleConfigWhitelist(c *gin.Context) {
+	var req whitelistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	configMu.Lock()
+	config.WhiteListModels = req.WhiteListModels
+	configMu.Unlock()
+	slog.Info("已更新模型白名单", "数量", len(req.WhiteListModels))
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+type thresholdRequest struct {
+	MinCharsModerate    *int  `json:"min_chars_moderate"`
+	FullContextModerate *bool `json:"full_context_moderate"`
+}
+
+// handleConfigThreshold 运行时更新审核触发字符数与是否启用全上下文审核
+func handleConfigThreshold(c *gin.Context) {
+	var req thresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	configMu.Lock()
+	if req.MinCharsModerate != nil {
+		config.MinCharsModerate = *req.MinCharsModerate
+	}
+	if req.FullContextModerate != nil {
+		config.FullContextModerate = *req.FullContextModerate
+	}
+	configMu.Unlock()
+	slog.Info("已更新审核阈值配置")
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// handleDeleteBanned 从持久化存储中删除一条违规记录
+func handleDeleteBanned(c *gin.Context) {
+	id := c.Param("id")
+	if err := bannedStore.Delete(id); err != nil {
+		slog.Error("删除违规记录失败", "ID", id, "错误信息", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting record"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// registerAdminRoutes 挂载受 Bearer token 保护的 /admin/* 路由
+func registerAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", adminAuthMiddleware())
+	admin.POST("/config/reload", handleConfigReload)
+	admin.POST("/config/warning", handleConfigWarning)
+	admin.POST("/config/whitelist", handleConfigWhitelist)
+	admin.POST("/config/threshold", handleConfigThreshold)
+	admin.DELETE("/banned/:id", handleDeleteBanned)
+	// 违规记录中含有来源 IP、请求 ID 及原始内容，与其他违规记录管理接口一样需要鉴权
+	admin.GET("/banned", GetBanndedContent)
+	admin.GET("/banned.json", handleGetBannedJSON)
+}
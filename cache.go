@@ -0,0 +1,174 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache 是审核结果缓存的通用接口，Get/Set 均以 moderation key 为键存储是否命中审核
+type Cache interface {
+	Get(key string) (flagged bool, ok bool)
+	Set(key string, flagged bool, ttl time.Duration)
+	IsExist(key string) bool
+}
+
+// moderationCacheKey 以 model+content 的 sha256 摘要作为缓存键，切换审核模型时天然失效
+func moderationCacheKey(model, content string) string {
+	sum := sha256.Sum256([]byte(model + ":" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+type lruEntry struct {
+	key     string
+	flagged bool
+	expires time.Time
+}
+
+// LRUCache 是进程内的有容量上限的 LRU 缓存，默认的缓存后端
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	return &LRUCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return false, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return false, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.flagged, true
+}
+
+func (c *LRUCache) Set(key string, flagged bool, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*lruEntry).flagged = flagged
+		el.Value.(*lruEntry).expires = time.Now().Add(ttl)
+		return
+	}
+	el := c.ll.PushFront(&lruEntry{key: key, flagged: flagged, expires: time.Now().Add(ttl)})
+	c.items[key] = el
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*lruEntry).key)
+		}
+	}
+}
+
+func (c *LRUCache) IsExist(key string) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+// RedisCache 是可选启用的 Redis 缓存后端，适合多实例部署共享缓存
+type RedisCache struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+func NewRedisCache(addr, password string, db int) *RedisCache {
+	return &RedisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+		ctx: context.Background(),
+	}
+}
+
+func (c *RedisCache) Get(key string) (bool, bool) {
+	val, err := c.client.Get(c.ctx, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Error("读取 Redis 缓存错误", "错误信息", err)
+		}
+		return false, false
+	}
+	return val == "1", true
+}
+
+func (c *RedisCache) Set(key string, flagged bool, ttl time.Duration) {
+	val := "0"
+	if flagged {
+		val = "1"
+	}
+	if err := c.client.Set(c.ctx, key, val, ttl).Err(); err != nil {
+		slog.Error("写入 Redis 缓存错误", "错误信息", err)
+	}
+}
+
+func (c *RedisCache) IsExist(key string) bool {
+	n, err := c.client.Exists(c.ctx, key).Result()
+	if err != nil {
+		slog.Error("检查 Redis 缓存错误", "错误信息", err)
+		return false
+	}
+	return n > 0
+}
+
+var moderationCache Cache
+
+// initializeCache 根据配置构建缓存后端，默认使用进程内 LRU
+func initializeCache() {
+	if config.CacheBackend == "redis" {
+		slog.Info("使用 Redis 作为审核结果缓存后端", "地址", config.RedisAddr)
+		moderationCache = NewRedisCache(config.RedisAddr, config.RedisPassword, config.RedisDB)
+		return
+	}
+	slog.Info("使用进程内 LRU 作为审核结果缓存后端", "容量", config.CacheLRUSize)
+	moderationCache = NewLRUCache(config.CacheLRUSize)
+}
+
+func flaggedCacheTTL() time.Duration {
+	if cfg := getConfig(); cfg.CacheFlaggedTTLSeconds > 0 {
+		return time.Duration(cfg.CacheFlaggedTTLSeconds) * time.Second
+	}
+	return time.Hour
+}
+
+func nonFlaggedCacheTTL() time.Duration {
+	if cfg := getConfig(); cfg.CacheNonFlaggedTTLSeconds > 0 {
+		return time.Duration(cfg.CacheNonFlaggedTTLSeconds) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+func cacheTTLFor(flagged bool) time.Duration {
+	if flagged {
+		return flaggedCacheTTL()
+	}
+	return nonFlaggedCacheTTL()
+}
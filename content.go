@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ContentPart 是 OpenAI Chat Completions 多模态 content 数组中的一个元素,
+// type 为 "text" 时使用 Text,为 "image_url" 时使用 ImageURL
+type ContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// Content 对应 message.content 字段,其在 OpenAI 协议中既可能是纯文本字符串,
+// 也可能是图文混合的 parts 数组;零值表示空文本内容
+type Content struct {
+	text  string
+	parts []ContentPart
+}
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" || trimmed == "" {
+		*c = Content{}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Content{text: s}
+		return nil
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("invalid message content: %w", err)
+	}
+	*c = Content{parts: parts}
+	return nil
+}
+
+func (c Content) MarshalJSON() ([]byte, error) {
+	if c.parts != nil {
+		return json.Marshal(c.parts)
+	}
+	return json.Marshal(c.text)
+}
+
+// Flatten 将 content 拍平为纯文本(多个 text part 用空格拼接)及图片 URL 列表
+func (c Content) Flatten() (string, []string) {
+	if c.parts == nil {
+		return c.text, nil
+	}
+	var texts []string
+	var images []string
+	for _, part := range c.parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if part.ImageURL != nil && part.ImageURL.URL != "" {
+				images = append(images, part.ImageURL.URL)
+			}
+		}
+	}
+	return strings.Join(texts, " "), images
+}
@@ -9,20 +9,22 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/HynoR/api-moderate/router"
 )
 
 var httpClient = &http.Client{
 	Timeout: 180 * time.Second, // 设定超时时间，防止请求挂起
 }
 
-var fileMutex sync.RWMutex
-
 type Config struct {
 	OpenAIAPIKey        string   `yaml:"openai_api_key"`
 	ModerationAPIURL    string   `yaml:"moderation_api_url"`
@@ -32,16 +34,38 @@ type Config struct {
 	MinCharsModerate    int      `yaml:"min_chars_moderate"`    // 达到多少字符时进行审核,不达到则绕过审核
 	FullContextModerate bool     `yaml:"full_context_moderate"` // 是否对完整上下文进行审核,如果启用，使用全部上下文消息，否则取用户最新一条消息
 	WhiteListModels     []string `yaml:"white_list_models"`     // 白名单模型,绕过审核
+	BannedRetentionDays int      `yaml:"banned_retention_days"` // 违规记录保留天数,启动及每小时清理一次,<=0 表示不清理
+
+	CacheBackend              string `yaml:"cache_backend"`                 // 审核结果缓存后端: "lru"(默认) 或 "redis"
+	CacheLRUSize              int    `yaml:"cache_lru_size"`                // 进程内 LRU 缓存容量
+	CacheFlaggedTTLSeconds    int    `yaml:"cache_flagged_ttl_seconds"`     // 命中审核的缓存存活时间(秒)
+	CacheNonFlaggedTTLSeconds int    `yaml:"cache_non_flagged_ttl_seconds"` // 未命中审核的缓存存活时间(秒)
+	RedisAddr                 string `yaml:"redis_addr"`                    // Redis 地址,cache_backend=redis 时生效
+	RedisPassword             string `yaml:"redis_password"`
+	RedisDB                   int    `yaml:"redis_db"`
+
+	AdminToken string `yaml:"admin_token"` // /admin/* 路由所需的 Bearer token
+
+	ModerateResponse           bool `yaml:"moderate_response"`              // 是否对上游返回的助手内容也进行审核
+	ResponseBufferChars        int  `yaml:"response_buffer_chars"`          // 流式响应滚动缓冲区保留的最近字符数,默认512
+	ResponseModerateEveryChars int  `yaml:"response_moderate_every_chars"`  // 每累积多少字符触发一次审核,默认256
+	ResponseModerateIntervalMs int  `yaml:"response_moderate_interval_ms"`  // 每隔多少毫秒至少触发一次审核,默认2000
+
+	RoutingRules []router.Rule `yaml:"routing_rules"` // 请求路由/改写规则,按声明顺序匹配,第一条命中的规则生效
+
+	BlockedCategories []string `yaml:"blocked_categories"` // 命中即拦截的审核分类,为空则沿用审核 API 返回的 flagged 字段
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string  `json:"role"`
+	Content Content `json:"content"`
 }
 
 type ModerationResponse struct {
 	Results []struct {
-		Flagged bool `json:"flagged"`
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
 	} `json:"results"`
 }
 
@@ -62,25 +86,63 @@ type Choice struct {
 }
 
 var config Config
+var configMu sync.RWMutex
+
+// getConfig 返回当前配置的一份拷贝,供处理函数并发读取而无需持有锁
+func getConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// setConfig 整体替换当前配置
+func setConfig(c Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
 
 func init() {
 	// Initialize configuration and logger
 	initializeConfig()
 	initializeLogger()
+	initializeStore()
+	initializeCache()
+	initializeRouter()
+}
+
+var appRouter *router.Router
+
+// initializeRouter 依据当前配置编译路由规则,规则非法时回退为空规则集(不改写任何请求)
+func initializeRouter() {
+	r, err := router.New(getConfig().RoutingRules)
+	if err != nil {
+		slog.Error("编译路由规则失败，使用空规则集", "错误信息", err)
+		r, _ = router.New(nil)
+	}
+	appRouter = r
 }
 
 func initializeConfig() {
-	configFile, err := os.ReadFile("config.yaml")
+	cfg, err := loadConfigFile("config.yaml")
 	if err != nil {
 		slog.Error("读取配置文件错误", "错误信息", err)
 		os.Exit(1)
 	}
+	setConfig(cfg)
+}
 
-	err = yaml.Unmarshal(configFile, &config)
+// loadConfigFile 读取并解析 config.yaml,供启动时和 /admin/config/reload 复用
+func loadConfigFile(path string) (Config, error) {
+	var cfg Config
+	configFile, err := os.ReadFile(path)
 	if err != nil {
-		slog.Error("解析配置文件错误", "错误信息", err)
-		os.Exit(1)
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(configFile, &cfg); err != nil {
+		return cfg, fmt.Errorf("解析配置文件错误: %w", err)
 	}
+	return cfg, nil
 }
 
 func initializeLogger() {
@@ -88,58 +150,125 @@ func initializeLogger() {
 	slog.SetDefault(logger)
 }
 
-func getUserContent(messages []Message) string {
+// getUserContentAndImages 拼接 user/system 消息的文本内容,并收集其中出现的图片 URL
+func getUserContentAndImages(messages []Message) (string, []string) {
 	userContents := make([]string, 0, len(messages))
+	var images []string
 	for _, msg := range messages {
 		if msg.Role == "user" || msg.Role == "system" {
-			userContents = append(userContents, msg.Content)
+			text, imgs := msg.Content.Flatten()
+			userContents = append(userContents, text)
+			images = append(images, imgs...)
 		}
 	}
-	return strings.Join(userContents, " ")
+	return strings.Join(userContents, " "), images
 }
 
-func moderateContent(content string) (bool, error) {
+// moderationInputPart 是多模态审核请求 input 数组中的一个元素
+type moderationInputPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+// moderateContent 调用审核 API，返回是否命中(已按 blocked_categories 策略过滤)、命中的分类及其分数；
+// 命中结果会先查缓存，命中/未命中分别写回缓存；缓存只保存是否命中，缓存命中时返回的分类与分数为 nil。
+// images 非空时以 omni-moderation-latest 的多模态格式提交
+func moderateContent(content string, images []string) (bool, map[string]bool, map[string]float64, error) {
+	cfg := getConfig()
 	model := "text-moderation-latest"
 	if len(content) < 4096 {
 		model = "omni-moderation-latest"
 	}
-	jsonData, err := json.Marshal(map[string]string{
+	if len(images) > 0 {
+		model = "omni-moderation-latest"
+	}
+
+	cacheKey := moderationCacheKey(model, content+"|"+strings.Join(images, "\n"))
+	if flagged, ok := moderationCache.Get(cacheKey); ok {
+		cacheHitTotal.Inc()
+		slog.Info("审核结果命中缓存", "是否标记", flagged, "模型", model)
+		return flagged, nil, nil, nil
+	}
+	cacheMissTotal.Inc()
+
+	var input interface{} = content
+	if len(images) > 0 {
+		parts := make([]moderationInputPart, 0, len(images)+1)
+		if content != "" {
+			parts = append(parts, moderationInputPart{Type: "text", Text: content})
+		}
+		for _, url := range images {
+			part := moderationInputPart{Type: "image_url"}
+			part.ImageURL = &struct {
+				URL string `json:"url"`
+			}{URL: url}
+			parts = append(parts, part)
+		}
+		input = parts
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
 		"model": model,
-		"input": content})
+		"input": input})
 	if err != nil {
-		return false, err
+		return false, nil, nil, err
 	}
 
-	req, err := http.NewRequest("POST", config.ModerationAPIURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", cfg.ModerationAPIURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return false, err
+		return false, nil, nil, err
 	}
 	setHeaders(req, map[string]string{
 		"Content-Type":  "application/json",
-		"Authorization": fmt.Sprintf("Bearer %s", config.OpenAIAPIKey),
+		"Authorization": fmt.Sprintf("Bearer %s", cfg.OpenAIAPIKey),
 	})
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return false, err
+		return false, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("moderation API returned status code %d", resp.StatusCode)
+		return false, nil, nil, fmt.Errorf("moderation API returned status code %d", resp.StatusCode)
 	}
 
 	var moderationResp ModerationResponse
 	if err := json.NewDecoder(resp.Body).Decode(&moderationResp); err != nil {
-		return false, err
+		return false, nil, nil, err
+	}
+
+	if len(moderationResp.Results) == 0 {
+		slog.Info("审核结果", "是否标记", false, "模型", model)
+		moderationCache.Set(cacheKey, false, cacheTTLFor(false))
+		return false, nil, nil, nil
 	}
 
-	flagged := len(moderationResp.Results) > 0 && moderationResp.Results[0].Flagged
+	result := moderationResp.Results[0]
+	flagged := isFlaggedByPolicy(cfg, result.Flagged, result.Categories)
 	slog.Info("审核结果", "是否标记", flagged, "模型", model)
 	if flagged {
 		slog.Info("审核拦截", "内容", content)
 	}
-	return flagged, nil
+	moderationCache.Set(cacheKey, flagged, cacheTTLFor(flagged))
+	return flagged, result.Categories, result.CategoryScores, nil
+}
+
+// isFlaggedByPolicy 在配置了 blocked_categories 时,只有命中其中至少一个分类才判定为拦截；
+// 未配置时沿用审核 API 返回的 flagged 字段
+func isFlaggedByPolicy(cfg Config, flagged bool, categories map[string]bool) bool {
+	if len(cfg.BlockedCategories) == 0 {
+		return flagged
+	}
+	for _, category := range cfg.BlockedCategories {
+		if categories[category] {
+			return true
+		}
+	}
+	return false
 }
 
 func setHeaders(req *http.Request, headers map[string]string) {
@@ -148,23 +277,24 @@ func setHeaders(req *http.Request, headers map[string]string) {
 	}
 }
 
-func logFlaggedContent(content string) {
+// logFlaggedContent 将一条违规记录写入 BoltDB 持久化存储
+func logFlaggedContent(requestID, sourceIP, model, content string, categories map[string]bool, scores map[string]float64, chunkIndex int, fullContext, lastUserMsg bool) {
 	slog.Warn("标记为不合规的内容", "内容", content)
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-	if err := appendToFile("log.txt", content+"\n"); err != nil {
-		slog.Error("写入日志文件错误", "错误信息", err)
-	}
-}
-
-func appendToFile(filename, content string) error {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	record := FlaggedRecord{
+		Timestamp:   time.Now(),
+		Model:       model,
+		RequestID:   requestID,
+		SourceIP:    sourceIP,
+		Categories:  categories,
+		Scores:      scores,
+		Content:     content,
+		ChunkIndex:  chunkIndex,
+		FullContext: fullContext,
+		LastUserMsg: lastUserMsg,
+	}
+	if _, err := bannedStore.Put(record); err != nil {
+		slog.Error("写入违规记录失败", "错误信息", err)
 	}
-	defer file.Close()
-	_, err = file.WriteString(content)
-	return err
 }
 
 func generateOpenAIStyleResponse(warningMessage, model string) OpenAIStyleResponse {
@@ -189,7 +319,7 @@ func generateOpenAIStyleResponse(warningMessage, model string) OpenAIStyleRespon
 }
 
 func handleFlaggedContent(c *gin.Context, isStream bool, model string) {
-	response := generateOpenAIStyleResponse(config.WarningMsg, model)
+	response := generateOpenAIStyleResponse(getConfig().WarningMsg, model)
 	if isStream {
 		c.Header("Content-Type", "text/event-stream")
 		c.Stream(func(w io.Writer) bool {
@@ -210,7 +340,9 @@ type OpenAIChatReq struct {
 }
 
 func handleChatCompletions(c *gin.Context) {
-	slog.Info("收到聊天完成请求")
+	cfg := getConfig()
+	requestID := uuid.New().String()
+	slog.Info("收到聊天完成请求", "请求ID", requestID)
 
 	// 读取原始请求体
 	body, err := io.ReadAll(c.Request.Body)
@@ -227,18 +359,18 @@ func handleChatCompletions(c *gin.Context) {
 		return
 	}
 
-	userContent := getUserContent(chatReq.Messages)
-	// 检查长度是否超过8192字符，如果超过替换model值
-	var replaceModel string
-	if len(userContent) > 10*1024 && len(userContent) < 100*1024 {
-		replaceModel = "glm-4-air"
-	}
-	if len(userContent) > 100*1024 {
-		replaceModel = "glm-4-flash"
-	}
-	if replaceModel != "" {
-		slog.Warn("请求体超过设定字符，替换model值", "新model", replaceModel, "字符数", len(userContent))
-		newBody, err := replaceModelValue(body, replaceModel)
+	userContent, userImages := getUserContentAndImages(chatReq.Messages)
+	lastUserText, lastUserImages := getLastUserMessage(chatReq.Messages)
+
+	decision := appRouter.Decide(router.RouteInput{
+		Model:              chatReq.Model,
+		TotalContentLength: len(userContent),
+		LastUserMessage:    lastUserText,
+		HasImages:          len(userImages) > 0,
+	})
+	if decision.Model != "" {
+		slog.Warn("路由规则命中，替换model值", "新model", decision.Model, "字符数", len(userContent))
+		newBody, err := replaceModelValue(body, decision.Model)
 		if err != nil {
 			slog.Error("替换model值错误", "错误信息", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error replacing model value"})
@@ -248,49 +380,62 @@ func handleChatCompletions(c *gin.Context) {
 	}
 
 	// 检查模型是否在白名单中
-	for _, model := range config.WhiteListModels {
+	for _, model := range cfg.WhiteListModels {
 		if model == chatReq.Model {
 			slog.Info("模型在白名单中，绕过审核", "模型", chatReq.Model)
-			proxyRequest(c, body)
+			proxyRequest(c, cfg, requestID, chatReq.Model, decision.UpstreamURL, decision.Headers, body)
 			return
 		}
 	}
 
-	if !config.FullContextModerate {
-		if len(chatReq.Messages) > 0 {
-			for i := len(chatReq.Messages) - 1; i >= 0; i-- {
-				if chatReq.Messages[i].Role == "user" {
-					userContent = chatReq.Messages[i].Content
-					break
-				}
-			}
-			//lastMessage := chatReq.Messages[len(chatReq.Messages)-1]
-			//if lastMessage.Role == "user" {
-			//	userContent = lastMessage.Content
-			//}
+	if decision.ForceBypassModeration {
+		slog.Info("路由规则命中，强制绕过审核", "模型", chatReq.Model)
+		proxyRequest(c, cfg, requestID, chatReq.Model, decision.UpstreamURL, decision.Headers, body)
+		return
+	}
+
+	if !cfg.FullContextModerate {
+		if lastUserText != "" || len(lastUserImages) > 0 {
+			userContent = lastUserText
+			userImages = lastUserImages
 		}
 	}
-	if len(userContent) >= config.MinCharsModerate {
+	if len(userContent) >= cfg.MinCharsModerate || len(userImages) > 0 {
 		userContents := splitText(userContent)
 		if len(userContents) > 1 {
 			slog.Info("内容过长，已分割", "分割片数", len(userContents))
 		}
-		for _, userContent := range userContents {
-			flagged, err := moderateContent(userContent)
+		for i, userContent := range userContents {
+			// 图片仅随第一个分片一起提交审核,避免重复分析
+			chunkImages := userImages
+			if i > 0 {
+				chunkImages = nil
+			}
+			flagged, categories, scores, err := moderateContent(userContent, chunkImages)
 			if err != nil {
 				slog.Error("审核错误", "错误信息", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Moderation error"})
 				return
 			}
 			if flagged {
-				logFlaggedContent(userContent)
+				logFlaggedContent(requestID, c.ClientIP(), chatReq.Model, userContent, categories, scores, i, cfg.FullContextModerate, !cfg.FullContextModerate)
 				handleFlaggedContent(c, chatReq.Stream, chatReq.Model)
 				return
 			}
 		}
 	}
 
-	proxyRequest(c, body)
+	proxyRequest(c, cfg, requestID, chatReq.Model, decision.UpstreamURL, decision.Headers, body)
+}
+
+// getLastUserMessage 返回消息列表中最后一条 user 角色消息的文本与图片 URL,不存在时返回空值
+func getLastUserMessage(messages []Message) (string, []string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content.Flatten()
+		}
+	}
+	return "", nil
 }
 
 // 以48K字符切割一个文本
@@ -332,10 +477,14 @@ func replaceModelValue(input []byte, newModelValue string) ([]byte, error) {
 	return output, nil
 }
 
-func proxyRequest(c *gin.Context, body []byte) {
+func proxyRequest(c *gin.Context, cfg Config, requestID, model, targetURL string, extraHeaders map[string]string, body []byte) {
 	slog.Info("正在转发请求到目标URL")
 
-	proxyReq, err := http.NewRequest("POST", config.TargetURL, bytes.NewBuffer(body))
+	if targetURL == "" {
+		targetURL = cfg.TargetURL
+	}
+
+	proxyReq, err := http.NewRequest("POST", targetURL, bytes.NewBuffer(body))
 	if err != nil {
 		slog.Error("创建代理请求错误", "错误信息", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error forwarding request"})
@@ -344,6 +493,9 @@ func proxyRequest(c *gin.Context, body []byte) {
 
 	copyHeaders(c.Request.Header, proxyReq.Header)
 	proxyReq.Header.Set("Content-Type", "application/json")
+	for key, value := range extraHeaders {
+		proxyReq.Header.Set(key, value)
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(proxyReq)
@@ -354,6 +506,18 @@ func proxyRequest(c *gin.Context, body []byte) {
 	}
 	defer resp.Body.Close()
 
+	if cfg.ModerateResponse {
+		contentType := resp.Header.Get("Content-Type")
+		switch {
+		case strings.Contains(contentType, "text/event-stream"):
+			streamModerateResponse(c, cfg, resp, requestID, model)
+			return
+		case strings.Contains(contentType, "application/json"):
+			moderateJSONResponse(c, cfg, resp, requestID, model)
+			return
+		}
+	}
+
 	copyHeaders(resp.Header, c.Writer.Header())
 	c.Status(resp.StatusCode)
 	io.Copy(c.Writer, resp.Body)
@@ -365,25 +529,48 @@ func copyHeaders(src, dest http.Header) {
 	}
 }
 
-// 读取log.txt 解析为网页内容
+// GetBanndedContent 从 BoltDB 读取最近的违规记录，渲染为简单网页
 func GetBanndedContent(c *gin.Context) {
-	fileMutex.RLock()
-	defer fileMutex.RUnlock()
-	file, err := os.ReadFile("log.txt")
+	records, _, err := bannedStore.List("", 200, "", time.Time{})
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"error": "读取文件错误"})
+		c.JSON(http.StatusOK, gin.H{"error": "读取违规记录错误"})
 		return
 	}
-	// 将文件内容转换为字符串，并将 '\n' 替换为 '<br>'
-	htmlContent := strings.ReplaceAll(string(file), "\n", "<br>")
-
-	// 将内容嵌入 HTML 中，确保换行符正常显示
-	htmlResponse := fmt.Sprintf("<html><body>%s</body></html>", htmlContent)
-
-	// 返回带有日志内容的 HTML
+	var builder strings.Builder
+	for _, rec := range records {
+		builder.WriteString(fmt.Sprintf("[%s] model=%s request_id=%s ip=%s<br>%s<br><br>",
+			rec.Timestamp.Format(time.RFC3339), rec.Model, rec.RequestID, rec.SourceIP,
+			strings.ReplaceAll(rec.Content, "\n", "<br>")))
+	}
+	htmlResponse := fmt.Sprintf("<html><body>%s</body></html>", builder.String())
 	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(htmlResponse))
 }
 
+// handleGetBannedJSON 提供分页查询违规记录的 JSON API，支持 cursor/limit/model/since
+func handleGetBannedJSON(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	var since time.Time
+	if sinceParam := c.Query("since"); sinceParam != "" {
+		since, err = time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected RFC3339"})
+			return
+		}
+	}
+	records, nextCursor, err := bannedStore.List(c.Query("cursor"), limit, c.Query("model"), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading banned content"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":        records,
+		"next_cursor": nextCursor,
+	})
+}
+
 func main() {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -391,7 +578,8 @@ func main() {
 		c.String(http.StatusOK, "Service Running...")
 	})
 	r.POST("/v1/chat/completions", handleChatCompletions)
-	r.GET("/api/getBannedContent", GetBanndedContent)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	registerAdminRoutes(r)
 	if err := r.Run(fmt.Sprintf(":%d", config.Port)); err != nil {
 		slog.Error("启动服务器失败", "错误信息", err)
 	}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHitTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "moderation_cache_hit_total",
+		Help: "审核结果缓存命中次数",
+	})
+	cacheMissTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "moderation_cache_miss_total",
+		Help: "审核结果缓存未命中次数",
+	})
+)
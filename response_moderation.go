@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamModerateResponse 转发 SSE 流式响应，同时对累积的助手输出做滚动审核；
+// 一旦命中审核，立即停止转发原始内容，改为输出一条警告 chunk 和 [DONE] 并结束流
+func streamModerateResponse(c *gin.Context, cfg Config, resp *http.Response, requestID, model string) {
+	bufferChars := cfg.ResponseBufferChars
+	if bufferChars <= 0 {
+		bufferChars = 512
+	}
+	everyChars := cfg.ResponseModerateEveryChars
+	if everyChars <= 0 {
+		everyChars = 256
+	}
+	intervalMs := cfg.ResponseModerateIntervalMs
+	if intervalMs <= 0 {
+		intervalMs = 2000
+	}
+	checkInterval := time.Duration(intervalMs) * time.Millisecond
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	writeLine := func(line string) {
+		fmt.Fprintf(c.Writer, "%s\n", line)
+		c.Writer.Flush()
+	}
+
+	// 响应头/状态码延迟到第一次审核通过之后才写出,避免客户端在内容被判定安全前
+	// 就收到一个 200 连同未经审核的原始分片;审核接口出错时按未命中处理,缓存的分片照常放行
+	headersWritten := false
+	var pendingLines []string
+	writeHeaders := func() {
+		if !headersWritten {
+			copyHeaders(resp.Header, c.Writer.Header())
+			c.Status(resp.StatusCode)
+			headersWritten = true
+		}
+	}
+	flushPending := func() {
+		writeHeaders()
+		for _, l := range pendingLines {
+			writeLine(l)
+		}
+		pendingLines = nil
+	}
+
+	var rollingBuffer strings.Builder
+	appendedSinceCheck := 0
+	lastCheck := time.Now()
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line {
+			// 非 data 行(空行、event/id 等)原样转发
+			if headersWritten {
+				writeLine(line)
+			} else {
+				pendingLines = append(pendingLines, line)
+			}
+			continue
+		}
+		if strings.TrimSpace(data) == "[DONE]" {
+			flushPending()
+			writeLine(line)
+			return
+		}
+
+		var chunk OpenAIStyleResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err == nil && len(chunk.Choices) > 0 {
+			content := chunk.Choices[0].Delta["content"]
+			if content != "" {
+				rollingBuffer.WriteString(content)
+				appendedSinceCheck += len([]rune(content))
+				if runes := []rune(rollingBuffer.String()); len(runes) > bufferChars {
+					// 按字符(rune)而非字节裁剪,避免切断多字节 UTF-8 字符产生无效编码
+					rollingBuffer.Reset()
+					rollingBuffer.WriteString(string(runes[len(runes)-bufferChars:]))
+				}
+			}
+		}
+
+		if !headersWritten {
+			pendingLines = append(pendingLines, line)
+		}
+
+		// 写出响应头前必须先完成一次审核,之后再退回到按字数/时间间隔的常规节奏
+		shouldCheck := rollingBuffer.Len() > 0 && (!headersWritten || appendedSinceCheck >= everyChars || time.Since(lastCheck) >= checkInterval)
+		if shouldCheck {
+			appendedSinceCheck = 0
+			lastCheck = time.Now()
+			buffered := rollingBuffer.String()
+			flagged, categories, scores, err := moderateContent(buffered, nil)
+			if err != nil {
+				slog.Error("响应审核错误", "错误信息", err)
+			} else if flagged {
+				slog.Warn("检测到助手输出违规，已拦截流式响应")
+				logFlaggedContent(requestID, c.ClientIP(), model, buffered, categories, scores, 0, false, false)
+				writeHeaders()
+				pendingLines = nil
+				warning := generateOpenAIStyleResponse(cfg.WarningMsg, model)
+				warningJSON, _ := json.Marshal(warning)
+				writeLine("data: " + string(warningJSON))
+				writeLine("data: [DONE]")
+				return
+			}
+		}
+
+		if headersWritten {
+			writeLine(line)
+		} else if shouldCheck {
+			// 首次审核未命中(或审核出错),写出响应头并放行此前缓存的原始行
+			flushPending()
+		}
+	}
+	flushPending()
+}
+
+// moderateJSONResponse 对非流式 JSON 响应中的助手内容做审核，命中则整体替换为警告消息
+func moderateJSONResponse(c *gin.Context, cfg Config, resp *http.Response, requestID, model string) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Error("读取上游响应错误", "错误信息", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading upstream response"})
+		return
+	}
+
+	var parsed struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	assistantContent := ""
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		parts := make([]string, 0, len(parsed.Choices))
+		for _, choice := range parsed.Choices {
+			parts = append(parts, choice.Message.Content)
+		}
+		assistantContent = strings.Join(parts, " ")
+	}
+
+	if assistantContent != "" {
+		flagged, categories, scores, err := moderateContent(assistantContent, nil)
+		if err != nil {
+			slog.Error("响应审核错误", "错误信息", err)
+		} else if flagged {
+			slog.Warn("检测到助手输出违规，已拦截响应")
+			logFlaggedContent(requestID, c.ClientIP(), model, assistantContent, categories, scores, 0, false, false)
+			// 不转发上游响应头:其 Content-Length 对应的是原始(已被丢弃)的响应体,
+			// 与替换后的警告消息体长度不一致会导致客户端读到被截断的响应;
+			// c.JSON 会自行写出匹配实际内容的 Content-Type/Content-Length
+			c.JSON(http.StatusOK, generateOpenAIStyleResponse(cfg.WarningMsg, model))
+			return
+		}
+	}
+
+	copyHeaders(resp.Header, c.Writer.Header())
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+}
@@ -0,0 +1,158 @@
+// Package router 将请求路由/改写规则从代码中抽离到可配置的规则集合,
+// 取代过去硬编码在 handleChatCompletions 中的按内容长度切换模型的逻辑。
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher 描述一条路由规则的匹配条件,字段为零值表示该条件不参与匹配
+type Matcher struct {
+	MinContentLength int    `yaml:"min_content_length"`
+	MaxContentLength int    `yaml:"max_content_length"`
+	ModelGlob        string `yaml:"model_glob"`
+	HasImages        *bool  `yaml:"has_images"`
+	LastMessageRegex string `yaml:"last_message_regex"`
+}
+
+// Action 描述匹配命中后执行的动作,字段为零值表示不执行该动作
+type Action struct {
+	RewriteModel          string            `yaml:"rewrite_model"`
+	RouteUpstream         string            `yaml:"route_upstream"`
+	SetHeaders            map[string]string `yaml:"set_headers"`
+	ForceBypassModeration bool              `yaml:"force_bypass_moderation"`
+}
+
+// Rule 是一条完整的匹配条件 + 动作规则
+type Rule struct {
+	Name    string  `yaml:"name"`
+	Matcher Matcher `yaml:"match"`
+	Action  Action  `yaml:"action"`
+}
+
+// RouteInput 是做路由决策所需的、从请求中提炼出的最小信息集合
+type RouteInput struct {
+	Model              string
+	TotalContentLength int
+	LastUserMessage    string
+	HasImages          bool
+}
+
+// RouteDecision 是一次路由决策的结果,零值表示不改变任何东西
+type RouteDecision struct {
+	Model                 string
+	UpstreamURL           string
+	Headers               map[string]string
+	ForceBypassModeration bool
+}
+
+type compiledRule struct {
+	rule         Rule
+	lastMsgRegex *regexp.Regexp
+}
+
+// Router 在启动时编译一组规则,之后对每个请求做路由决策
+type Router struct {
+	rules []compiledRule
+}
+
+// New 编译给定的规则集。规则按声明顺序匹配,第一条命中的规则生效,其余被忽略
+func New(rules []Rule) (*Router, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		cr := compiledRule{rule: rule}
+		if rule.Matcher.LastMessageRegex != "" {
+			re, err := regexp.Compile(rule.Matcher.LastMessageRegex)
+			if err != nil {
+				return nil, fmt.Errorf("规则 %q 的 last_message_regex 编译失败: %w", rule.Name, err)
+			}
+			cr.lastMsgRegex = re
+		}
+		compiled = append(compiled, cr)
+	}
+	return &Router{rules: compiled}, nil
+}
+
+// Decide 返回第一条匹配规则对应的 RouteDecision;均不匹配时返回零值 RouteDecision
+func (r *Router) Decide(input RouteInput) RouteDecision {
+	if r == nil {
+		return RouteDecision{}
+	}
+	for _, cr := range r.rules {
+		if cr.matches(input) {
+			return cr.decision()
+		}
+	}
+	return RouteDecision{}
+}
+
+func (cr compiledRule) matches(input RouteInput) bool {
+	m := cr.rule.Matcher
+	if m.MinContentLength > 0 && input.TotalContentLength < m.MinContentLength {
+		return false
+	}
+	if m.MaxContentLength > 0 && input.TotalContentLength > m.MaxContentLength {
+		return false
+	}
+	if m.ModelGlob != "" && !globMatch(m.ModelGlob, input.Model) {
+		return false
+	}
+	if m.HasImages != nil && *m.HasImages != input.HasImages {
+		return false
+	}
+	if cr.lastMsgRegex != nil && !cr.lastMsgRegex.MatchString(input.LastUserMessage) {
+		return false
+	}
+	return true
+}
+
+func (cr compiledRule) decision() RouteDecision {
+	a := cr.rule.Action
+	return RouteDecision{
+		Model:                 a.RewriteModel,
+		UpstreamURL:           a.RouteUpstream,
+		Headers:               a.SetHeaders,
+		ForceBypassModeration: a.ForceBypassModeration,
+	}
+}
+
+// globMatch 支持任意数量 "*" 通配符的简单模型名匹配,例如 "gpt-4*"、"*-vision"、"*mini*"
+func globMatch(glob, value string) bool {
+	if glob == "" || glob == "*" {
+		return true
+	}
+	if !strings.Contains(glob, "*") {
+		return glob == value
+	}
+
+	segments := strings.Split(glob, "*")
+	last := len(segments) - 1
+
+	if prefix := segments[0]; prefix != "" {
+		if !strings.HasPrefix(value, prefix) {
+			return false
+		}
+		value = value[len(prefix):]
+	}
+	if suffix := segments[last]; suffix != "" {
+		if !strings.HasSuffix(value, suffix) {
+			return false
+		}
+		value = value[:len(value)-len(suffix)]
+	}
+
+	// 中间分段必须按声明顺序依次出现
+	for _, segment := range segments[1:last] {
+		if segment == "" {
+			continue
+		}
+		idx := strings.Index(value, segment)
+		if idx == -1 {
+			return false
+		}
+		value = value[idx+len(segment):]
+	}
+	return true
+}
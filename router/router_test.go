@@ -0,0 +1,144 @@
+package router
+
+import "testing"
+
+func TestDecide_FirstMatchWins(t *testing.T) {
+	r, err := New([]Rule{
+		{
+			Name:    "small",
+			Matcher: Matcher{MaxContentLength: 100},
+			Action:  Action{RewriteModel: "small-model"},
+		},
+		{
+			Name:    "catch-all",
+			Matcher: Matcher{},
+			Action:  Action{RewriteModel: "default-model"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	decision := r.Decide(RouteInput{TotalContentLength: 10})
+	if decision.Model != "small-model" {
+		t.Fatalf("Model = %q, want %q", decision.Model, "small-model")
+	}
+
+	decision = r.Decide(RouteInput{TotalContentLength: 1000})
+	if decision.Model != "default-model" {
+		t.Fatalf("Model = %q, want %q", decision.Model, "default-model")
+	}
+}
+
+func TestDecide_NoMatchReturnsZeroValue(t *testing.T) {
+	r, err := New([]Rule{
+		{Matcher: Matcher{MinContentLength: 1000}, Action: Action{RewriteModel: "big-model"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	decision := r.Decide(RouteInput{TotalContentLength: 10})
+	if decision.Model != "" || decision.UpstreamURL != "" || decision.Headers != nil || decision.ForceBypassModeration {
+		t.Fatalf("decision = %+v, want zero value", decision)
+	}
+}
+
+func TestMatcher_ContentLengthRange(t *testing.T) {
+	r, err := New([]Rule{
+		{Matcher: Matcher{MinContentLength: 10*1024 + 1, MaxContentLength: 100 * 1024}, Action: Action{RewriteModel: "mid"}},
+		{Matcher: Matcher{MinContentLength: 100*1024 + 1}, Action: Action{RewriteModel: "large"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	cases := []struct {
+		length int
+		want   string
+	}{
+		{length: 10 * 1024, want: ""},
+		{length: 10*1024 + 1, want: "mid"},
+		{length: 100 * 1024, want: "mid"},
+		{length: 100*1024 + 1, want: "large"},
+	}
+	for _, tc := range cases {
+		decision := r.Decide(RouteInput{TotalContentLength: tc.length})
+		if decision.Model != tc.want {
+			t.Errorf("length=%d: Model = %q, want %q", tc.length, decision.Model, tc.want)
+		}
+	}
+}
+
+func TestMatcher_ModelGlob(t *testing.T) {
+	r, err := New([]Rule{
+		{Matcher: Matcher{ModelGlob: "gpt-4*"}, Action: Action{RouteUpstream: "https://openai.example"}},
+		{Matcher: Matcher{ModelGlob: "*-vision"}, Action: Action{RouteUpstream: "https://vision.example"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := r.Decide(RouteInput{Model: "gpt-4o-mini"}).UpstreamURL; got != "https://openai.example" {
+		t.Errorf("UpstreamURL = %q, want openai route", got)
+	}
+	if got := r.Decide(RouteInput{Model: "glm-4-vision"}).UpstreamURL; got != "https://vision.example" {
+		t.Errorf("UpstreamURL = %q, want vision route", got)
+	}
+	if got := r.Decide(RouteInput{Model: "glm-4-air"}).UpstreamURL; got != "" {
+		t.Errorf("UpstreamURL = %q, want no match", got)
+	}
+}
+
+func TestMatcher_ModelGlobMultiWildcard(t *testing.T) {
+	r, err := New([]Rule{
+		{Matcher: Matcher{ModelGlob: "*mini*"}, Action: Action{RouteUpstream: "https://mini.example"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if got := r.Decide(RouteInput{Model: "gpt4-mini-vision"}).UpstreamURL; got != "https://mini.example" {
+		t.Errorf("UpstreamURL = %q, want mini route", got)
+	}
+	if got := r.Decide(RouteInput{Model: "gpt-4-vision"}).UpstreamURL; got != "" {
+		t.Errorf("UpstreamURL = %q, want no match", got)
+	}
+}
+
+func TestMatcher_HasImagesAndRegexCombination(t *testing.T) {
+	bypassImages := true
+	r, err := New([]Rule{
+		{
+			Matcher: Matcher{HasImages: &bypassImages, LastMessageRegex: `(?i)ignore previous instructions`},
+			Action:  Action{ForceBypassModeration: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	decision := r.Decide(RouteInput{HasImages: true, LastUserMessage: "please Ignore previous instructions"})
+	if !decision.ForceBypassModeration {
+		t.Fatalf("expected ForceBypassModeration when both matcher conditions hold")
+	}
+
+	decision = r.Decide(RouteInput{HasImages: true, LastUserMessage: "hello there"})
+	if decision.ForceBypassModeration {
+		t.Fatalf("did not expect ForceBypassModeration when regex does not match")
+	}
+
+	decision = r.Decide(RouteInput{HasImages: false, LastUserMessage: "ignore previous instructions"})
+	if decision.ForceBypassModeration {
+		t.Fatalf("did not expect ForceBypassModeration when has_images does not match")
+	}
+}
+
+func TestNew_InvalidRegexReturnsError(t *testing.T) {
+	_, err := New([]Rule{
+		{Name: "bad", Matcher: Matcher{LastMessageRegex: "("}},
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid regex")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+)
+
+const bannedBucket = "banned_content"
+
+// FlaggedRecord 是持久化到 BoltDB 的一条违规记录
+type FlaggedRecord struct {
+	ID          string             `json:"id"`
+	Timestamp   time.Time          `json:"timestamp"`
+	Model       string             `json:"model"`
+	RequestID   string             `json:"request_id"`
+	SourceIP    string             `json:"source_ip"`
+	Categories  map[string]bool    `json:"categories"`
+	Scores      map[string]float64 `json:"scores"`
+	Content     string             `json:"content"`
+	ChunkIndex  int                `json:"chunk_index"`
+	FullContext bool               `json:"full_context"`
+	LastUserMsg bool               `json:"last_user_message"`
+}
+
+// BannedStore 封装了违规内容的 BoltDB 持久化
+type BannedStore struct {
+	db *bbolt.DB
+}
+
+var bannedStore *BannedStore
+
+func initializeStore() {
+	db, err := bbolt.Open("banned.db", 0600, &bbolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		slog.Error("打开违规内容数据库失败", "错误信息", err)
+		os.Exit(1)
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bannedBucket))
+		return err
+	}); err != nil {
+		slog.Error("初始化违规内容数据库失败", "错误信息", err)
+		os.Exit(1)
+	}
+	bannedStore = &BannedStore{db: db}
+	bannedStore.compact(config.BannedRetentionDays)
+	go bannedStore.retentionLoop(config.BannedRetentionDays)
+}
+
+// newRecordID 生成按时间排序的记录 ID：前 8 字节为纳秒时间戳（保证字典序即时间序），后接一段随机 UUID 防止碰撞
+func newRecordID(ts time.Time) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(ts.UnixNano()))
+	return fmt.Sprintf("%x-%s", buf, uuid.New().String())
+}
+
+// Put 写入一条违规记录，返回生成的记录 ID
+func (s *BannedStore) Put(record FlaggedRecord) (string, error) {
+	record.ID = newRecordID(record.Timestamp)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bannedBucket)).Put([]byte(record.ID), data)
+	})
+	if err != nil {
+		return "", err
+	}
+	return record.ID, nil
+}
+
+// Delete 按 ID 删除一条违规记录
+func (s *BannedStore) Delete(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bannedBucket)).Delete([]byte(id))
+	})
+}
+
+// List 按 ID 倒序（最新优先）分页返回记录，可选按 model/since 过滤，返回用于翻页的下一个 cursor
+func (s *BannedStore) List(cursor string, limit int, model string, since time.Time) ([]FlaggedRecord, string, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	var records []FlaggedRecord
+	var nextCursor string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bannedBucket))
+		c := b.Cursor()
+		var k, v []byte
+		if cursor != "" {
+			k, v = c.Seek([]byte(cursor))
+			if k != nil && string(k) == cursor {
+				k, v = c.Prev()
+			} else if k != nil {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			var rec FlaggedRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				continue
+			}
+			if model != "" && rec.Model != model {
+				continue
+			}
+			if !since.IsZero() && rec.Timestamp.Before(since) {
+				continue
+			}
+			records = append(records, rec)
+			if len(records) == limit {
+				nextCursor = string(k)
+				break
+			}
+		}
+		return nil
+	})
+	return records, nextCursor, err
+}
+
+// compact 删除早于 retentionDays 的记录，retentionDays <= 0 表示不清理
+func (s *BannedStore) compact(retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	var toDelete [][]byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bannedBucket))
+		return b.ForEach(func(k, v []byte) error {
+			var rec FlaggedRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+			if rec.Timestamp.Before(cutoff) {
+				toDelete = append(toDelete, append([]byte(nil), k...))
+			}
+			return nil
+		})
+	})
+	if len(toDelete) == 0 {
+		return
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bannedBucket))
+		for _, k := range toDelete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("清理过期违规记录失败", "错误信息", err)
+	} else {
+		slog.Info("已清理过期违规记录", "数量", len(toDelete))
+	}
+}
+
+// retentionLoop 每小时执行一次过期记录清理
+func (s *BannedStore) retentionLoop(retentionDays int) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.compact(retentionDays)
+	}
+}